@@ -59,8 +59,8 @@ import (
 //   the list of available for allocation node.
 // * The process repeats until the allocation list is empty.
 // * After allocation is done, in order to find which node is responsible for
-//   a given entity, we simply hash it and use binary search on the allocation plan
-//   to find the node which contains the region containing the key hash.
+//   a given entity, we simply hash it and divide by the (fixed) range size
+//   to directly compute which region contains the key hash.
 
 // Hasher struct is used to assign a list of ids to the hash space ring
 // and to determine which id is responsible for a given key.
@@ -69,43 +69,142 @@ type Hasher struct {
 	seed      uint32
 	ranges    int
 	rangeSize uint64
+	// weights holds the per-id weight used to (re)compute countReqs.
+	// nil means every id carries the same, implicit weight of 1.
+	weights map[string]int
+	// boundEpsilon is the epsilon NewBounded was created with, or nil if
+	// the Hasher isn't load-bounded.
+	boundEpsilon *float64
+	// hashFunc computes the hash used both to seed a node's random source
+	// and to look up a key's range. Defaults to Murmur3.
+	hashFunc HashFunc
 	// range idx -> peer id
 	rangeAllocations []string
-	// Range idx -> upper id bound
-	// For example, given rangeValues = [100, 500, 1000]
-	// means that range 0 is [0, 100),
-	//            range 1 is [100, 500)
-	//            range 2 is [500, 1000)
-	//            range 3 is [1000, math.MaxUint64)
-	rangeValues []uint64
+	// plan is the reverse of rangeAllocations: id -> ranges it owns.
+	plan map[string][]int
+}
+
+// HashFunc hashes key into a uint64 using seed. It's used both to hash ids
+// into random-source seeds and to look up the range a key falls into.
+type HashFunc func(key []byte, seed uint32) uint64
+
+// Option configures optional Hasher behaviour through New, NewWeighted and
+// NewBounded.
+type Option func(*Hasher)
+
+// WithHashFunc overrides the hash function used by a Hasher, which defaults
+// to Murmur3. Useful for callers already standardized on e.g. xxhash, FNV or
+// a cryptographic hash, so they don't have to pull in Murmur3 just for this
+// ring.
+func WithHashFunc(fn HashFunc) Option {
+	return func(h *Hasher) {
+		h.hashFunc = fn
+	}
 }
 
 // ids must not be empty!
-func New(ids []string, ranges int, seed uint32) *Hasher {
+func New(ids []string, ranges int, seed uint32, opts ...Option) *Hasher {
+	h := newRing(ids, ranges, seed, opts...)
+	h.rangeAllocations, h.plan = h.computeAllocations(h.ids, h.countReqs(h.ids))
+
+	return h
+}
+
+// NewWeighted is like New, but lets every id carry a numeric weight/capacity
+// so it ends up owning a proportional share of ranges instead of an equal
+// one. Given the same weights, ranges and seed, every caller computes the
+// same plan, same as New.
+//
+// weights must not be empty and every weight must be a positive integer.
+func NewWeighted(weights map[string]int, ranges int, seed uint32, opts ...Option) *Hasher {
+	ids := make([]string, 0, len(weights))
+
+	for id := range weights {
+		ids = append(ids, id)
+	}
+
+	h := newRing(ids, ranges, seed, opts...)
+	h.weights = weights
+	h.rangeAllocations, h.plan = h.computeAllocations(h.ids, h.countReqs(h.ids))
+
+	return h
+}
+
+// NewBounded is like New, but caps the number of ranges any single id can
+// own at ceil((1+epsilon) * ranges / len(ids)), bounding the worst-case skew
+// a single id can end up with (see "Consistent Hashing with Bounded Loads").
+// A typical epsilon is 0.25.
+//
+// epsilon must be >= 0: a negative epsilon can cap ids below what's needed
+// to cover every range, which would leave the allocation loop unable to
+// ever finish.
+func NewBounded(ids []string, ranges int, seed uint32, epsilon float64, opts ...Option) *Hasher {
+	if epsilon < 0 {
+		panic("gconhash: NewBounded epsilon must be >= 0")
+	}
+
+	h := newRing(ids, ranges, seed, opts...)
+	h.boundEpsilon = &epsilon
+	h.rangeAllocations, h.plan = h.computeAllocations(h.ids, h.countReqs(h.ids))
+
+	return h
+}
+
+// newRing builds the sorted id list and a bare Hasher shared by all
+// constructors. It does not compute an allocation plan - callers set any
+// weights/boundEpsilon first, then call computeAllocations.
+func newRing(ids []string, ranges int, seed uint32, opts ...Option) *Hasher {
 	sort.Strings(ids)
 
-	rangeSize := math.MaxUint64 / uint64(ranges)
-	rangeValues := make([]uint64, ranges)
-	curRangeVal := uint64(0)
+	h := &Hasher{
+		ids:       ids,
+		seed:      seed,
+		ranges:    ranges,
+		rangeSize: math.MaxUint64 / uint64(ranges),
+		hashFunc:  murmur3.Sum64WithSeed,
+	}
 
-	idx := 0
+	for _, opt := range opts {
+		opt(h)
+	}
 
-	for idx < ranges {
-		rangeValues[idx] = curRangeVal + rangeSize
+	return h
+}
 
-		idx++
-		curRangeVal += rangeSize
+// countReqs returns how many ranges each id in ids should own: the cap
+// derived from h.boundEpsilon if the Hasher is bounded, a proportional share of
+// h.weights if set, an equal share otherwise.
+func (h *Hasher) countReqs(ids []string) map[string]int {
+	if h.boundEpsilon != nil {
+		return boundedCountReqs(ids, h.ranges, *h.boundEpsilon)
 	}
 
-	hasher := &Hasher{
-		ids:              ids,
-		seed:             seed,
-		ranges:           ranges,
-		rangeSize:        rangeSize,
-		rangeAllocations: make([]string, ranges),
-		rangeValues:      rangeValues,
+	if h.weights != nil {
+		return weightedCountReqs(ids, h.weights, h.ranges)
 	}
 
+	return equalCountReqs(ids, h.ranges)
+}
+
+// boundedCountReqs caps every id at the same ceil((1+epsilon) * ranges /
+// len(ids)) share. The caps are allowed to sum to more than ranges - the
+// allocation loop simply stops once all ranges are handed out, so an id
+// only ever reaches the cap, never exceeds it.
+func boundedCountReqs(ids []string, ranges int, epsilon float64) map[string]int {
+	perIdCap := int(math.Ceil((1 + epsilon) * float64(ranges) / float64(len(ids))))
+
+	countReqs := map[string]int{}
+
+	for _, id := range ids {
+		countReqs[id] = perIdCap
+	}
+
+	return countReqs
+}
+
+// equalCountReqs splits ranges as evenly as possible among ids, handing the
+// remainder out to the first ids in sorted order.
+func equalCountReqs(ids []string, ranges int) map[string]int {
 	share := ranges / len(ids)
 	// We need to always allocate a full amount of ranges
 	rem := ranges % len(ids)
@@ -123,22 +222,210 @@ func New(ids []string, ranges int, seed uint32) *Hasher {
 		countReqs[id] = share + additional
 	}
 
-	hp := newHashPool(ids, ranges, countReqs, hasher)
+	return countReqs
+}
+
+// weightedCountReqs splits ranges among ids proportionally to their weight
+// (defaulting to 1 for an id missing from weights), using the largest
+// remainder method: every id first gets its floored share, then the
+// leftover ranges go to the ids with the largest fractional remainder,
+// ties broken by id so the result stays deterministic.
+func weightedCountReqs(ids []string, weights map[string]int, ranges int) map[string]int {
+	totalWeight := 0
+
+	for _, id := range ids {
+		w := weights[id]
 
+		if w <= 0 {
+			w = 1
+		}
+
+		totalWeight += w
+	}
+
+	countReqs := map[string]int{}
+	remainders := make([]string, 0, len(ids))
+	fracs := map[string]int64{}
+	assigned := 0
+
+	for _, id := range ids {
+		w := weights[id]
+
+		if w <= 0 {
+			w = 1
+		}
+
+		base := ranges * w / totalWeight
+		countReqs[id] = base
+		fracs[id] = int64(ranges)*int64(w) - int64(base)*int64(totalWeight)
+		assigned += base
+		remainders = append(remainders, id)
+	}
+
+	sort.Slice(remainders, func(i, j int) bool {
+		a, b := remainders[i], remainders[j]
+
+		if fracs[a] != fracs[b] {
+			return fracs[a] > fracs[b]
+		}
+
+		return a < b
+	})
+
+	for i := 0; i < ranges-assigned; i++ {
+		countReqs[remainders[i]]++
+	}
+
+	return countReqs
+}
+
+// computeAllocations runs the allocation algorithm for the given ids and
+// per-id range counts, using h's ranges and hash seed. It also returns the
+// plan, the reverse of rangeAllocations (id -> ranges it owns).
+func (h *Hasher) computeAllocations(ids []string, countReqs map[string]int) ([]string, map[string][]int) {
+	hp := newHashPool(ids, h.ranges, countReqs, h)
+
+	rangeAllocations := make([]string, h.ranges)
 	plan := map[string][]int{}
 
-	for r := 0; r < ranges; r++ {
+	for r := 0; r < h.ranges; r++ {
 		id := hp.allocate(r)
 
-		hasher.rangeAllocations[r] = id
+		rangeAllocations[r] = id
 		plan[id] = append(plan[id], r)
 	}
 
-	return hasher
+	return rangeAllocations, plan
+}
+
+// RangeDiff describes how a single id's ranges changed after an Add or
+// Remove call: Gained lists ranges newly assigned to it, Lost lists ranges
+// taken away from it.
+type RangeDiff struct {
+	Gained []int
+	Lost   []int
+}
+
+// Add inserts a new id into the cluster and recomputes the allocation plan
+// in place. It returns, per affected id, the ranges it gained and lost as a
+// result - callers can use this to migrate only the keys that moved instead
+// of diffing two full plans.
+//
+// If the Hasher was built with NewWeighted, the new id gets a default
+// weight of 1.
+//
+// Add is a no-op (returning an empty diff) if id is already a member of the
+// cluster - membership events can be delivered more than once, and retrying
+// a join should never corrupt the allocation.
+func (h *Hasher) Add(id string) map[string]RangeDiff {
+	for _, existing := range h.ids {
+		if existing == id {
+			return map[string]RangeDiff{}
+		}
+	}
+
+	if h.weights != nil {
+		weights := make(map[string]int, len(h.weights)+1)
+
+		for existing, w := range h.weights {
+			weights[existing] = w
+		}
+
+		weights[id] = 1
+		h.weights = weights
+	}
+
+	ids := append(append([]string{}, h.ids...), id)
+
+	return h.reallocate(ids)
+}
+
+// Remove takes an id out of the cluster and recomputes the allocation plan
+// in place, redistributing its ranges among the remaining ids. It returns
+// the same kind of diff as Add.
+//
+// Remove is a no-op (returning an empty diff) if id is not a member of the
+// cluster - membership events can be delivered more than once, and retrying
+// a leave should never trigger a pointless reallocation.
+//
+// Remove panics if id is the cluster's last remaining member: an empty
+// cluster can't own any ranges, so there's nothing left to hash keys onto.
+func (h *Hasher) Remove(id string) map[string]RangeDiff {
+	found := false
+
+	for _, existing := range h.ids {
+		if existing == id {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return map[string]RangeDiff{}
+	}
+
+	if len(h.ids) == 1 {
+		panic("gconhash: Remove cannot remove the last id in the cluster")
+	}
+
+	ids := make([]string, 0, len(h.ids)-1)
+
+	for _, existing := range h.ids {
+		if existing != id {
+			ids = append(ids, existing)
+		}
+	}
+
+	if h.weights != nil {
+		weights := make(map[string]int, len(h.weights))
+
+		for existing, w := range h.weights {
+			if existing != id {
+				weights[existing] = w
+			}
+		}
+
+		h.weights = weights
+	}
+
+	return h.reallocate(ids)
+}
+
+func (h *Hasher) reallocate(ids []string) map[string]RangeDiff {
+	sort.Strings(ids)
+
+	oldAllocations := h.rangeAllocations
+	newAllocations, newPlan := h.computeAllocations(ids, h.countReqs(ids))
+
+	h.ids = ids
+	h.rangeAllocations = newAllocations
+	h.plan = newPlan
+
+	return diffAllocations(oldAllocations, newAllocations)
+}
+
+func diffAllocations(old, updated []string) map[string]RangeDiff {
+	diffs := map[string]RangeDiff{}
+
+	for r := range old {
+		if old[r] == updated[r] {
+			continue
+		}
+
+		lost := diffs[old[r]]
+		lost.Lost = append(lost.Lost, r)
+		diffs[old[r]] = lost
+
+		gained := diffs[updated[r]]
+		gained.Gained = append(gained.Gained, r)
+		diffs[updated[r]] = gained
+	}
+
+	return diffs
 }
 
 func (h *Hasher) Hash(key string) uint64 {
-	return murmur3.Sum64WithSeed([]byte(key), h.seed)
+	return h.hashFunc([]byte(key), h.seed)
 }
 
 // Given a key, find an id (a peer) responsible for it
@@ -146,39 +433,120 @@ func (h *Hasher) IdForKey(key string) string {
 	return h.rangeAllocations[h.RangeForKey(key)]
 }
 
+// IdsForKey returns up to n distinct ids responsible for key: the primary
+// id returned by IdForKey, followed by the owners of the following ranges
+// on the ring (wrapping around at the end), skipping ids already in the
+// result. Only ids currently holding at least one range are candidates, so
+// it can return fewer than n ids even if the cluster (h.ids) has n or more
+// members - an id with zero ranges (e.g. when ranges < len(h.ids)) is
+// simply not reachable by walking the ring. This is useful for replicated
+// storage or fallback routing, where callers want a primary plus n-1
+// replicas without having to know the ring layout. IdsForKey returns nil if
+// n <= 0.
+func (h *Hasher) IdsForKey(key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+
+	start := h.RangeForKey(key)
+
+	ids := make([]string, 0, n)
+	seen := map[string]bool{}
+
+	for i := 0; i < h.ranges && len(ids) < n; i++ {
+		id := h.rangeAllocations[(start+i)%h.ranges]
+
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
 // Get all the ranges for a given id (a peer)
 func (h *Hasher) Ranges(id string) []int {
-	var ranges []int
+	if len(h.plan[id]) == 0 {
+		return nil
+	}
+
+	ranges := make([]int, len(h.plan[id]))
+	copy(ranges, h.plan[id])
+
+	return ranges
+}
+
+// Plan returns the current allocation plan: id -> the ranges it owns. Every
+// id in the cluster is present, even one holding no ranges (nil in that
+// case).
+func (h *Hasher) Plan() map[string][]int {
+	plan := make(map[string][]int, len(h.ids))
 
-	for rid, peer := range h.rangeAllocations {
-		if peer == id {
-			ranges = append(ranges, rid)
+	for _, id := range h.ids {
+		ranges := h.plan[id]
+
+		if len(ranges) == 0 {
+			plan[id] = nil
+			continue
 		}
+
+		cp := make([]int, len(ranges))
+		copy(cp, ranges)
+		plan[id] = cp
 	}
 
-	return ranges
+	return plan
 }
 
-// Return a range which a key falls into
-func (h *Hasher) RangeForKey(key string) int {
-	return h.search(h.Hash(key), 0, h.ranges)
+// LoadDistribution returns, per id, how many ranges it currently owns.
+// Every id in the cluster is present, even one holding zero ranges.
+func (h *Hasher) LoadDistribution() map[string]int {
+	load := make(map[string]int, len(h.ids))
+
+	for _, id := range h.ids {
+		load[id] = len(h.plan[id])
+	}
+
+	return load
+}
+
+// AverageLoad returns the average number of ranges per id.
+func (h *Hasher) AverageLoad() float64 {
+	return float64(h.ranges) / float64(len(h.ids))
 }
 
-func (h *Hasher) search(hash uint64, l, r int) int {
-	if l > r {
-		return 0
+// MaxLoad returns the largest number of ranges owned by any single id.
+func (h *Hasher) MaxLoad() int {
+	max := 0
+
+	for _, ranges := range h.plan {
+		if len(ranges) > max {
+			max = len(ranges)
+		}
 	}
 
-	m := (l + r) / 2
-	cur := h.rangeValues[m]
+	return max
+}
+
+// Return a range which a key falls into
+//
+// rangeSize is a fixed-width partition size and h.ranges*rangeSize covers
+// [0, math.MaxUint64) up to a remainder, so the range a hash falls into can
+// be computed directly by division instead of searched for. The final
+// range absorbs the partial remainder left over when math.MaxUint64 %
+// ranges != 0, so it's clamped to the last index rather than computed from
+// the division.
+func (h *Hasher) RangeForKey(key string) int {
+	r := int(h.Hash(key) / h.rangeSize)
 
-	if hash >= cur-h.rangeSize && hash < cur {
-		return m
-	} else if hash < cur {
-		return h.search(hash, l, m-1)
-	} else {
-		return h.search(hash, m+1, r)
+	if r >= h.ranges {
+		r = h.ranges - 1
 	}
+
+	return r
 }
 
 type hashPool struct {
@@ -191,8 +559,13 @@ type hashPool struct {
 }
 
 func newHashPool(ids []string, ranges int, countReqs map[string]int, hasher *Hasher) *hashPool {
+	// hp.ids is mutated in place as ids are allocated (see allocate below),
+	// so it must never alias the caller's slice - copy it first.
+	poolIds := make([]string, len(ids))
+	copy(poolIds, ids)
+
 	hp := &hashPool{
-		ids:       ids,
+		ids:       poolIds,
 		hasher:    hasher,
 		rsources:  map[string]*rand.Rand{},
 		idMatched: map[string]int{},
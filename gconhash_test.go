@@ -21,7 +21,10 @@
 package gconhash
 
 import (
+	"hash/fnv"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -57,3 +60,296 @@ func TestHasher(t *testing.T) {
 	require.Equal(t, "host2", hasher.IdForKey("key8"))
 	require.Equal(t, "host3", hasher.IdForKey("key75"))
 }
+
+func TestNewWeighted(t *testing.T) {
+	hasher := NewWeighted(map[string]int{
+		"host1": 2,
+		"host2": 1,
+		"host3": 1,
+	}, 8, 10)
+
+	require.Len(t, hasher.Ranges("host1"), 4)
+	require.Len(t, hasher.Ranges("host2"), 2)
+	require.Len(t, hasher.Ranges("host3"), 2)
+
+	// Same weights, ranges and seed must always produce the same plan.
+	again := NewWeighted(map[string]int{
+		"host1": 2,
+		"host2": 1,
+		"host3": 1,
+	}, 8, 10)
+
+	require.Equal(t, hasher.rangeAllocations, again.rangeAllocations)
+}
+
+func TestWithHashFunc(t *testing.T) {
+	calls := 0
+	fnvWithSeed := func(key []byte, seed uint32) uint64 {
+		calls++
+
+		h := fnv.New64a()
+		_, _ = h.Write(key)
+		_, _ = h.Write([]byte{byte(seed), byte(seed >> 8), byte(seed >> 16), byte(seed >> 24)})
+
+		return h.Sum64()
+	}
+
+	hasher := New([]string{"host1", "host2", "host3"}, 9, 10, WithHashFunc(fnvWithSeed))
+
+	require.Greater(t, calls, 0)
+	require.NotEmpty(t, hasher.IdForKey("key1"))
+}
+
+func TestIdsForKey(t *testing.T) {
+	hasher := New([]string{"host1", "host2", "host3"}, 9, 10)
+
+	ids := hasher.IdsForKey("key1", 2)
+
+	require.Len(t, ids, 2)
+	require.Equal(t, hasher.IdForKey("key1"), ids[0])
+	require.NotEqual(t, ids[0], ids[1])
+
+	// Asking for more replicas than there are distinct ids should just
+	// return all of them.
+	all := hasher.IdsForKey("key1", 10)
+	require.Len(t, all, 3)
+
+	// A non-positive n must not panic (make([]string, 0, n) would for a
+	// negative n).
+	require.Nil(t, hasher.IdsForKey("key1", 0))
+	require.Nil(t, hasher.IdsForKey("key1", -1))
+}
+
+func FuzzRangeForKey(f *testing.F) {
+	hasher := New([]string{"host1", "host2", "host3", "host4", "host5"}, 101, 10)
+
+	f.Add("some-key")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, key string) {
+		r := hasher.RangeForKey(key)
+		hash := hasher.Hash(key)
+		lo := uint64(r) * hasher.rangeSize
+
+		require.GreaterOrEqual(t, hash, lo)
+
+		if r < hasher.ranges-1 {
+			require.Less(t, hash, lo+hasher.rangeSize)
+		}
+	})
+}
+
+func TestLoadDistributionAndPlan(t *testing.T) {
+	hasher := New([]string{"host1", "host2", "host3"}, 9, 10)
+
+	plan := hasher.Plan()
+	load := hasher.LoadDistribution()
+
+	total := 0
+
+	for id, ranges := range plan {
+		require.Equal(t, load[id], len(ranges))
+		require.ElementsMatch(t, hasher.Ranges(id), ranges)
+
+		total += len(ranges)
+	}
+
+	require.Equal(t, 9, total)
+	require.Equal(t, float64(9)/3, hasher.AverageLoad())
+	require.Equal(t, 3, hasher.MaxLoad())
+
+	// Plan() must return copies, not the internal slices.
+	for id := range plan {
+		plan[id][0] = 999
+	}
+
+	require.NotEqual(t, plan, hasher.Plan())
+}
+
+func TestLoadDistributionAndPlanIncludeZeroRangeIds(t *testing.T) {
+	hasher := New([]string{"a", "b", "c"}, 1, 10)
+
+	load := hasher.LoadDistribution()
+	plan := hasher.Plan()
+
+	require.Len(t, load, 3)
+	require.Len(t, plan, 3)
+
+	total := 0
+	for _, id := range []string{"a", "b", "c"} {
+		require.Contains(t, load, id)
+		require.Contains(t, plan, id)
+		total += load[id]
+	}
+
+	require.Equal(t, 1, total)
+}
+
+func TestNewBounded(t *testing.T) {
+	ids := []string{"host1", "host2", "host3", "host4", "host5"}
+	ranges := 100
+	epsilon := 0.25
+
+	hasher := NewBounded(ids, ranges, 10, epsilon)
+
+	// NewBounded must not corrupt the caller's ids slice by aliasing it
+	// into the mutating hashPool.
+	require.ElementsMatch(t, []string{"host1", "host2", "host3", "host4", "host5"}, ids)
+
+	cap := int(math.Ceil((1 + epsilon) * float64(ranges) / float64(len(ids))))
+
+	total := 0
+
+	for _, id := range ids {
+		n := len(hasher.Ranges(id))
+
+		require.LessOrEqual(t, n, cap)
+
+		total += n
+	}
+
+	require.Equal(t, ranges, total)
+}
+
+func TestNewBoundedRejectsNegativeEpsilon(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e"}
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		require.Panics(t, func() {
+			NewBounded(ids, 100, 10, -0.99)
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("NewBounded with a negative epsilon hung instead of failing fast")
+	}
+}
+
+func TestNewDoesNotCorruptIds(t *testing.T) {
+	hasher := New([]string{"host1", "host2", "host3"}, 9, 10)
+
+	// hashPool.allocate mutates its own id list as ids fill up their
+	// share of ranges; that must never leak into h.ids itself.
+	require.ElementsMatch(t, []string{"host1", "host2", "host3"}, hasher.ids)
+}
+
+func TestHasherAddExisting(t *testing.T) {
+	hasher := New([]string{"host1", "host2", "host3"}, 9, 10)
+
+	before := hasher.Plan()
+	done := make(chan map[string]RangeDiff, 1)
+
+	go func() {
+		done <- hasher.Add("host1")
+	}()
+
+	select {
+	case diff := <-done:
+		require.Empty(t, diff)
+		require.ElementsMatch(t, []string{"host1", "host2", "host3"}, hasher.ids)
+		require.Equal(t, before, hasher.Plan())
+	case <-time.After(2 * time.Second):
+		t.Fatal("Add with an already-present id hung")
+	}
+}
+
+func TestHasherAddRemove(t *testing.T) {
+	hasher := New([]string{"host1", "host2", "host3"}, 9, 10)
+
+	diff := hasher.Add("host4")
+
+	require.ElementsMatch(t, []string{"host1", "host2", "host3", "host4"}, hasher.ids)
+	require.NotEmpty(t, diff["host4"].Gained)
+	require.Empty(t, diff["host4"].Lost)
+
+	for _, r := range diff["host4"].Gained {
+		require.Equal(t, "host4", hasher.rangeAllocations[r])
+	}
+
+	for id, d := range diff {
+		for _, r := range d.Lost {
+			require.NotEqual(t, id, hasher.rangeAllocations[r])
+		}
+	}
+
+	diff = hasher.Remove("host4")
+
+	require.ElementsMatch(t, []string{"host1", "host2", "host3"}, hasher.ids)
+	require.Empty(t, diff["host4"].Gained)
+	require.NotEmpty(t, diff["host4"].Lost)
+	require.Len(t, hasher.rangeAllocations, 9)
+}
+
+func TestHasherRemovePrunesWeights(t *testing.T) {
+	hasher := NewWeighted(map[string]int{
+		"host1": 2,
+		"host2": 1,
+		"host3": 1,
+	}, 8, 10)
+
+	hasher.Remove("host1")
+
+	require.NotContains(t, hasher.weights, "host1")
+}
+
+func TestHasherRemoveMissing(t *testing.T) {
+	hasher := New([]string{"host1", "host2", "host3"}, 9, 10)
+
+	before := hasher.Plan()
+	done := make(chan map[string]RangeDiff, 1)
+
+	go func() {
+		done <- hasher.Remove("host4")
+	}()
+
+	select {
+	case diff := <-done:
+		require.Empty(t, diff)
+		require.ElementsMatch(t, []string{"host1", "host2", "host3"}, hasher.ids)
+		require.Equal(t, before, hasher.Plan())
+	case <-time.After(2 * time.Second):
+		t.Fatal("Remove with a missing id hung")
+	}
+}
+
+func TestHasherRemoveRejectsLastId(t *testing.T) {
+	newHashers := map[string]func() *Hasher{
+		"plain": func() *Hasher {
+			return New([]string{"a"}, 10, 10)
+		},
+		"weighted": func() *Hasher {
+			return NewWeighted(map[string]int{"a": 1}, 10, 10)
+		},
+		"bounded": func() *Hasher {
+			return NewBounded([]string{"a"}, 10, 10, 0.25)
+		},
+	}
+
+	for name, newHasher := range newHashers {
+		name, newHasher := name, newHasher
+
+		t.Run(name, func(t *testing.T) {
+			hasher := newHasher()
+			done := make(chan struct{})
+
+			go func() {
+				defer close(done)
+
+				require.Panics(t, func() {
+					hasher.Remove("a")
+				})
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(3 * time.Second):
+				t.Fatal("Remove of the last id hung instead of failing fast")
+			}
+		})
+	}
+}